@@ -0,0 +1,397 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/livepeer/ai-worker/worker"
+	"github.com/livepeer/go-livepeer/clog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+const (
+	// aiWorkerErrorMimeType is set on the Content-Type header of a result submission
+	// when the body is a plain-text error message rather than a pipeline result.
+	aiWorkerErrorMimeType = "livepeer/ai-worker-error"
+	// aiWorkerStreamMimeType is set on the Content-Type header of a result submission
+	// when the body carries a single server-sent-events frame of an in-progress job.
+	aiWorkerStreamMimeType = "text/event-stream"
+	// aiWorkerRetryMimeType is set on the Content-Type header of a result submission
+	// when the worker is NACKing the job (at capacity, or a transient failure) and is
+	// asking the orchestrator to requeue it onto another eligible worker.
+	aiWorkerRetryMimeType = "livepeer/ai-worker-retry"
+	protoVerAIWorker      = "livepeer-ai-worker-1.0"
+
+	aiResultsPath = "/aiResults"
+
+	// aiRetryBaseBackoff is the Retry-After duration suggested for the first retry
+	// attempt; it doubles with every subsequent attempt carried on the notification.
+	aiRetryBaseBackoff = 2 * time.Second
+	// aiRetryMaxBackoff caps the Retry-After duration suggested to the orchestrator.
+	aiRetryMaxBackoff = 30 * time.Second
+
+	// aiRetryMaxAttempts bounds both how many times this worker will NACK the same job
+	// for retry before giving up, and how many times requeueAIJob (ai_worker_requeue.go)
+	// will hand a NACKed job to another worker on the orchestrator side.
+	aiRetryMaxAttempts = 3
+)
+
+// aiLLMStreamEvent is one item produced on the channel returned by AIWorker.LLM for a
+// streaming "llm" request: either a token delta (Chunk) or a terminal failure (Err). The
+// channel is closed after the final event.
+type aiLLMStreamEvent struct {
+	Chunk *worker.LLMResponse
+	Err   error
+}
+
+// runAIJob runs an AI job on the AIWorker and submits the result, or error, back to the
+// orchestrator that dispatched it.
+func runAIJob(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob) {
+	ctx := clog.AddVal(context.Background(), "taskId", strconv.FormatInt(notify.TaskId, 10))
+	ctx = clog.AddVal(ctx, "pipeline", notify.Pipeline)
+
+	if _, err := core.PipelineToCapability(notify.Pipeline); err != nil {
+		clog.Errorf(ctx, "AIWorker cannot process requested job modelID=%s", notify.ModelID)
+		sendAIResultError(ctx, node, orchAddr, httpc, notify, errors.New("no workers can process job requested"))
+		return
+	}
+
+	if !node.AIWorker.HasCapacity(notify.Pipeline, notify.ModelID) {
+		retryOrFail(ctx, node, orchAddr, httpc, notify, errors.New("worker is at capacity"))
+		return
+	}
+
+	if notify.Pipeline == "llm" && isLLMStreamRequest(notify.RequestData) {
+		runAIJobStream(ctx, node, orchAddr, httpc, notify)
+		return
+	}
+
+	start := time.Now()
+	res, err := processAIJob(ctx, node, notify)
+	recordAIJobLatency(notify.Pipeline, notify.ModelID, time.Since(start))
+	if err != nil {
+		var unrecoverableErr *core.UnrecoverableError
+		if errors.As(err, &unrecoverableErr) {
+			sendAIResultError(ctx, node, orchAddr, httpc, notify, err)
+			panic(err)
+		}
+		var invalidReqErr *aiInvalidRequestError
+		if errors.As(err, &invalidReqErr) {
+			// retrying a malformed request would just fail identically on the next
+			// worker, so report it as a terminal error instead of NACKing it
+			sendAIResultError(ctx, node, orchAddr, httpc, notify, err)
+			return
+		}
+		retryOrFail(ctx, node, orchAddr, httpc, notify, err)
+		return
+	}
+
+	sendAIResult(ctx, node, orchAddr, httpc, notify, res)
+}
+
+// aiInvalidRequestError marks a job failure as caused by a malformed request rather than
+// a worker-side condition, so runAIJob reports it as a terminal error instead of NACKing
+// it for a retry that would just fail identically on the next worker.
+type aiInvalidRequestError struct {
+	pipeline string
+}
+
+func (e *aiInvalidRequestError) Error() string {
+	return fmt.Sprintf("AI request not correct for %s pipeline", e.pipeline)
+}
+
+// processAIJob decodes the request data for notify.Pipeline and dispatches it to the
+// matching AIWorker method, returning the pipeline-specific response.
+func processAIJob(ctx context.Context, node *core.LivepeerNode, notify *net.NotifyAIJob) (interface{}, error) {
+	switch notify.Pipeline {
+	case "text-to-image":
+		var req worker.GenTextToImageJSONRequestBody
+		if err := json.Unmarshal(notify.RequestData, &req); err != nil {
+			return nil, &aiInvalidRequestError{pipeline: notify.Pipeline}
+		}
+		return node.AIWorker.TextToImage(ctx, req)
+	case "image-to-image":
+		var req worker.GenImageToImageMultipartRequestBody
+		if err := decodeAIRequestWithInput(notify, &req, &req.Image); err != nil {
+			return nil, err
+		}
+		return node.AIWorker.ImageToImage(ctx, req)
+	case "upscale":
+		var req worker.GenUpscaleMultipartRequestBody
+		if err := decodeAIRequestWithInput(notify, &req, &req.Image); err != nil {
+			return nil, err
+		}
+		return node.AIWorker.Upscale(ctx, req)
+	case "image-to-video":
+		var req worker.GenImageToVideoMultipartRequestBody
+		if err := decodeAIRequestWithInput(notify, &req, &req.Image); err != nil {
+			return nil, err
+		}
+		return node.AIWorker.ImageToVideo(ctx, req)
+	case "audio-to-text":
+		var req worker.GenAudioToTextMultipartRequestBody
+		if err := decodeAIRequestWithInput(notify, &req, &req.Audio); err != nil {
+			return nil, err
+		}
+		return node.AIWorker.AudioToText(ctx, req)
+	case "segment-anything-2":
+		var req worker.GenSegmentAnything2MultipartRequestBody
+		if err := decodeAIRequestWithInput(notify, &req, &req.Image); err != nil {
+			return nil, err
+		}
+		return node.AIWorker.SegmentAnything2(ctx, req)
+	case "llm":
+		var req worker.GenLLMFormdataRequestBody
+		if err := json.Unmarshal(notify.RequestData, &req); err != nil {
+			return nil, &aiInvalidRequestError{pipeline: notify.Pipeline}
+		}
+		return node.AIWorker.LLM(ctx, req)
+	default:
+		return nil, errors.New("no workers can process job requested")
+	}
+}
+
+// decodeAIRequestWithInput unmarshals notify.RequestData into req and, when notify.Url
+// is set, downloads the referenced input (image or audio) into input.
+func decodeAIRequestWithInput(notify *net.NotifyAIJob, req interface{}, input *worker.File) error {
+	if err := json.Unmarshal(notify.RequestData, req); err != nil {
+		return &aiInvalidRequestError{pipeline: notify.Pipeline}
+	}
+	if notify.Url == "" {
+		return nil
+	}
+	resp, err := http.Get(notify.Url)
+	if err != nil {
+		return fmt.Errorf("error downloading input for %s pipeline: %w", notify.Pipeline, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading input for %s pipeline: %w", notify.Pipeline, err)
+	}
+	*input = data
+	return nil
+}
+
+// isLLMStreamRequest reports whether an "llm" pipeline request asked for its response to
+// be streamed back token-by-token rather than as a single buffered result.
+func isLLMStreamRequest(requestData []byte) bool {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(requestData, &req); err != nil {
+		return false
+	}
+	return req.Stream
+}
+
+// runAIJobStream runs a streaming "llm" job, posting each token delta returned on
+// AIWorker.LLM's result channel back to the orchestrator as its own SSE frame. The stream
+// is terminated either by a "[DONE]" sentinel frame, or, if the job fails or posting a
+// frame to the orchestrator fails mid-stream, by an "event: error" frame carrying the same
+// error body as the non-streaming error path.
+func runAIJobStream(ctx context.Context, node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob) {
+	var req worker.GenLLMFormdataRequestBody
+	if err := json.Unmarshal(notify.RequestData, &req); err != nil {
+		sendAIResultError(ctx, node, orchAddr, httpc, notify, &aiInvalidRequestError{pipeline: notify.Pipeline})
+		return
+	}
+
+	// genCtx is canceled the moment the orchestrator stops accepting frames for this job,
+	// so a worker that keeps generating tokens after the caller has given up is stopped
+	// promptly rather than running a stream nobody is reading.
+	genCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res, err := node.AIWorker.LLM(genCtx, req)
+	if err != nil {
+		var unrecoverableErr *core.UnrecoverableError
+		if errors.As(err, &unrecoverableErr) {
+			sendAIResultError(ctx, node, orchAddr, httpc, notify, err)
+			panic(err)
+		}
+		// the stream hasn't started yet - a transient failure here is retryable the same
+		// way a non-streaming job's failure is, since nothing has been sent to the
+		// orchestrator yet that a second attempt would duplicate
+		retryOrFail(ctx, node, orchAddr, httpc, notify, err)
+		return
+	}
+
+	stream, ok := res.(chan aiLLMStreamEvent)
+	if !ok {
+		// AIWorker did not honor the streaming request, fall back to a single result.
+		sendAIResult(ctx, node, orchAddr, httpc, notify, res)
+		return
+	}
+
+	seq := 0
+	for ev := range stream {
+		if ev.Err != nil {
+			sendAIStreamError(node, orchAddr, httpc, notify, seq, ev.Err)
+			panicIfUnrecoverable(ev.Err)
+			return
+		}
+		if err := postAIStreamChunk(node, orchAddr, httpc, notify, seq, ev.Chunk); err != nil {
+			clog.Errorf(ctx, "Error submitting stream chunk seq=%d err=%v", seq, err)
+			// cancel genCtx so a worker that keeps generating tokens after the orchestrator
+			// has stopped accepting them is told to stop, rather than running a stream
+			// nobody is reading.
+			cancel()
+			sendAIStreamError(node, orchAddr, httpc, notify, seq, err)
+			return
+		}
+		seq++
+	}
+	sendAIStreamDone(node, orchAddr, httpc, notify, seq)
+}
+
+func postAIStreamChunk(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, seq int, chunk *worker.LLMResponse) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("data: %s\n\n", data)
+	return postAIStreamFrame(node, orchAddr, httpc, notify, seq, body)
+}
+
+func sendAIStreamDone(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, seq int) {
+	postAIStreamFrame(node, orchAddr, httpc, notify, seq, "data: [DONE]\n\n")
+}
+
+func sendAIStreamError(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, seq int, streamErr error) {
+	postAIStreamFrame(node, orchAddr, httpc, notify, seq, fmt.Sprintf("event: error\ndata: %s\n\n", streamErr.Error()))
+}
+
+func postAIStreamFrame(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, seq int, frame string) error {
+	req, err := http.NewRequest("POST", "https://"+orchAddr+aiResultsPath, bytes.NewReader([]byte(frame)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", aiWorkerStreamMimeType)
+	req.Header.Set("TaskId", strconv.FormatInt(notify.TaskId, 10))
+	req.Header.Set("Seq", strconv.Itoa(seq))
+	req.Header.Set("Credentials", node.OrchSecret)
+	req.Header.Set("Authorization", protoVerAIWorker)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sendAIResult submits a successful pipeline result back to the orchestrator as JSON.
+func sendAIResult(ctx context.Context, node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		sendAIResultError(ctx, node, orchAddr, httpc, notify, err)
+		return
+	}
+	if err := postAIResult(node, orchAddr, httpc, notify, "application/json", data); err != nil {
+		clog.Errorf(ctx, "Error submitting result err=%v", err)
+	}
+}
+
+// sendAIResultError submits a job failure back to the orchestrator as a plain-text body
+// tagged with aiWorkerErrorMimeType.
+func sendAIResultError(ctx context.Context, node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, resultErr error) {
+	clog.Errorf(ctx, "Error processing AI job err=%v", resultErr)
+	if err := postAIResult(node, orchAddr, httpc, notify, aiWorkerErrorMimeType, []byte(resultErr.Error())); err != nil {
+		clog.Errorf(ctx, "Error submitting error result err=%v", err)
+	}
+}
+
+// retryOrFail NACKs a job for retry, unless notify has already been attempted
+// aiRetryMaxAttempts times, in which case it gives up and reports resultErr as a terminal
+// error instead of leaving the job to bounce between workers indefinitely. The orchestrator
+// side of the NACK this produces is requeueAIJob (ai_worker_requeue.go).
+func retryOrFail(ctx context.Context, node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, resultErr error) {
+	if notify.Attempt >= aiRetryMaxAttempts {
+		clog.Errorf(ctx, "AIWorker job exhausted retry attempts, giving up attempt=%d err=%v", notify.Attempt, resultErr)
+		sendAIResultError(ctx, node, orchAddr, httpc, notify, resultErr)
+		return
+	}
+	clog.Infof(ctx, "AIWorker asking orchestrator to retry attempt=%d err=%v", notify.Attempt, resultErr)
+	sendAIResultRetry(ctx, node, orchAddr, httpc, notify, resultErr)
+}
+
+// sendAIResultRetry NACKs a job the worker could not complete for a transient reason
+// (out of capacity, or a recoverable pipeline error). It reports the next attempt number
+// and a suggested backoff so the orchestrator's RemoteAIWorkerManager can requeue the job
+// onto another eligible worker rather than surfacing the error immediately.
+func sendAIResultRetry(ctx context.Context, node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, resultErr error) {
+	req, err := http.NewRequest("POST", "https://"+orchAddr+aiResultsPath, bytes.NewReader([]byte(resultErr.Error())))
+	if err != nil {
+		clog.Errorf(ctx, "Error building retry result err=%v", err)
+		return
+	}
+	req.Header.Set("Content-Type", aiWorkerRetryMimeType)
+	req.Header.Set("TaskId", strconv.FormatInt(notify.TaskId, 10))
+	req.Header.Set("Attempt", strconv.FormatInt(notify.Attempt+1, 10))
+	req.Header.Set("Retry-After", strconv.Itoa(int(aiRetryBackoff(notify.Attempt).Seconds())))
+	req.Header.Set("Credentials", node.OrchSecret)
+	req.Header.Set("Authorization", protoVerAIWorker)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		clog.Errorf(ctx, "Error submitting retry result err=%v", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+}
+
+// aiRetryBackoff doubles aiRetryBaseBackoff for every attempt already made, capped at
+// aiRetryMaxBackoff.
+func aiRetryBackoff(attempt int64) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	if attempt > 32 {
+		// avoids an oversized shift count; the backoff is capped well before this anyway
+		return aiRetryMaxBackoff
+	}
+	backoff := aiRetryBaseBackoff << attempt
+	if backoff > aiRetryMaxBackoff || backoff <= 0 {
+		return aiRetryMaxBackoff
+	}
+	return backoff
+}
+
+func postAIResult(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJob, contentType string, body []byte) error {
+	req, err := http.NewRequest("POST", "https://"+orchAddr+aiResultsPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("TaskId", strconv.FormatInt(notify.TaskId, 10))
+	req.Header.Set("Credentials", node.OrchSecret)
+	req.Header.Set("Authorization", protoVerAIWorker)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// panicIfUnrecoverable panics if err signals that the AIWorker hit an unrecoverable
+// failure, mirroring the transcoding path's handling of core.UnrecoverableError.
+func panicIfUnrecoverable(err error) {
+	var unrecoverableErr *core.UnrecoverableError
+	if errors.As(err, &unrecoverableErr) {
+		panic(err)
+	}
+}
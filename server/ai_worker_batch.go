@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"github.com/livepeer/go-livepeer/clog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+const aiBatchResultsPath = "/aiResultsBatch"
+
+// errAIBatchNotImplemented signals that the orchestrator does not understand batched
+// job results, so runAIJobBatch should fall back to dispatching the batch sequentially.
+var errAIBatchNotImplemented = errors.New("batch job dispatch not implemented")
+
+// aiBatchFallbackOrchs remembers, for the lifetime of the process, which orchestrators
+// have rejected a batch submission as unimplemented, so later batches for that
+// orchestrator skip straight to the sequential path instead of re-discovering it.
+var (
+	aiBatchFallbackOrchsMu sync.Mutex
+	aiBatchFallbackOrchs   = make(map[string]bool)
+)
+
+func aiBatchFallbackKnown(orchAddr string) bool {
+	aiBatchFallbackOrchsMu.Lock()
+	defer aiBatchFallbackOrchsMu.Unlock()
+	return aiBatchFallbackOrchs[orchAddr]
+}
+
+func rememberAIBatchFallback(orchAddr string) {
+	aiBatchFallbackOrchsMu.Lock()
+	aiBatchFallbackOrchs[orchAddr] = true
+	aiBatchFallbackOrchsMu.Unlock()
+}
+
+// aiBatchItemResult is the outcome of a single sub-request within a batch.
+type aiBatchItemResult struct {
+	taskId int64
+	result interface{}
+	err    error
+}
+
+// runAIJobBatch runs every sub-request in notify concurrently (bounded by
+// AIWorker.HasCapacity) and posts the combined results back to the orchestrator as a
+// single multipart response keyed by TaskId. If this worker isn't capable of serving
+// batches, or the orchestrator is already known to reject them, the batch is run as a
+// sequence of ordinary single-job dispatches. If the orchestrator rejects the submission as
+// unimplemented, the already computed results are resubmitted one item per request and the
+// orchestrator is remembered as batch-incapable for the rest of the process.
+func runAIJobBatch(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJobBatch) {
+	ctx := clog.AddVal(context.Background(), "pipeline", notify.Pipeline)
+
+	if !node.Capabilities.HasCapability(core.Capability_AIBatchJobs) || aiBatchFallbackKnown(orchAddr) {
+		clog.Infof(ctx, "Batch dispatch unavailable for orchAddr=%s, falling back to sequential jobs", orchAddr)
+		runAIJobBatchSequential(node, orchAddr, httpc, notify)
+		return
+	}
+
+	results := dispatchAIJobBatchItems(ctx, node, notify)
+	unrecoverable := firstUnrecoverableBatchErr(results)
+
+	if err := postAIBatchResult(node, orchAddr, httpc, notify, results); err != nil {
+		if errors.Is(err, errAIBatchNotImplemented) {
+			clog.Infof(ctx, "Orchestrator does not support batch results, resubmitting already computed results individually")
+			rememberAIBatchFallback(orchAddr)
+			postAIBatchItemsIndividually(node, orchAddr, httpc, results)
+			if unrecoverable != nil {
+				panic(unrecoverable)
+			}
+			return
+		}
+		clog.Errorf(ctx, "Error submitting batch result err=%v", err)
+	}
+
+	if unrecoverable != nil {
+		panic(unrecoverable)
+	}
+}
+
+// aiBatchMaxConcurrency bounds how many items of a single batch are in flight against the
+// AIWorker at once. Without a bound, every item's HasCapacity check runs before any of the
+// others have actually started, so capacity consumed by the rest of the batch itself is
+// never reflected; gating on a semaphore means an item's check happens only once an earlier
+// one has actually claimed (and, on completion, freed) a slot.
+const aiBatchMaxConcurrency = 4
+
+// dispatchAIJobBatchItems runs each item in notify against the AIWorker concurrently, up to
+// aiBatchMaxConcurrency at a time, skipping (and failing) any item submitted once the worker
+// reports it's out of capacity rather than queuing it behind the others.
+func dispatchAIJobBatchItems(ctx context.Context, node *core.LivepeerNode, notify *net.NotifyAIJobBatch) []aiBatchItemResult {
+	results := make([]aiBatchItemResult, len(notify.Items))
+	sem := make(chan struct{}, aiBatchMaxConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range notify.Items {
+		i, item := i, item
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !node.AIWorker.HasCapacity(notify.Pipeline, notify.ModelID) {
+				results[i] = aiBatchItemResult{taskId: item.TaskId, err: errors.New("no workers can process job requested")}
+				return
+			}
+			res, err := processAIJob(ctx, node, &net.NotifyAIJob{
+				TaskId:      item.TaskId,
+				Pipeline:    notify.Pipeline,
+				ModelID:     notify.ModelID,
+				Url:         item.Url,
+				RequestData: item.RequestData,
+			})
+			results[i] = aiBatchItemResult{taskId: item.TaskId, result: res, err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// firstUnrecoverableBatchErr returns the first item error in results that signals an
+// unrecoverable AIWorker failure, matching runAIJob's single-item panic semantics.
+func firstUnrecoverableBatchErr(results []aiBatchItemResult) error {
+	for _, r := range results {
+		var unrecoverableErr *core.UnrecoverableError
+		if errors.As(r.err, &unrecoverableErr) {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// runAIJobBatchSequential dispatches every item in notify one at a time through the
+// ordinary single-job path, preserving the same per-item error and panic semantics as a
+// standalone runAIJob call. It is only used when a batch was never attempted (the worker
+// or orchestrator is already known to not support one); once items have actually been run
+// through the AIWorker, postAIBatchItemsIndividually resubmits their results instead of
+// paying for the pipeline work a second time.
+func runAIJobBatchSequential(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJobBatch) {
+	for _, item := range notify.Items {
+		runAIJob(node, orchAddr, httpc, &net.NotifyAIJob{
+			TaskId:      item.TaskId,
+			Pipeline:    notify.Pipeline,
+			ModelID:     notify.ModelID,
+			Url:         item.Url,
+			RequestData: item.RequestData,
+		})
+	}
+}
+
+// postAIBatchItemsIndividually resubmits the already-computed results of a batch to the
+// ordinary single-job results endpoint, one item per request, without rerunning any of the
+// underlying AIWorker pipeline work. It's used when the orchestrator rejects a batch
+// submission after the items have already been dispatched, so the fallback doesn't double
+// the AI work just to match the transport the orchestrator understands.
+func postAIBatchItemsIndividually(node *core.LivepeerNode, orchAddr string, httpc *http.Client, results []aiBatchItemResult) {
+	for _, r := range results {
+		ctx := clog.AddVal(context.Background(), "taskId", strconv.FormatInt(r.taskId, 10))
+		notify := &net.NotifyAIJob{TaskId: r.taskId}
+		if r.err != nil {
+			sendAIResultError(ctx, node, orchAddr, httpc, notify, r.err)
+			continue
+		}
+		sendAIResult(ctx, node, orchAddr, httpc, notify, r.result)
+	}
+}
+
+// postAIBatchResult submits every item in results as its own part of a multipart/mixed
+// response, each part tagged with a TaskId header and either a JSON result or an
+// aiWorkerErrorMimeType plain-text error for that item. Returns errAIBatchNotImplemented
+// if the orchestrator signals it doesn't understand batched results.
+func postAIBatchResult(node *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifyAIJobBatch, results []aiBatchItemResult) error {
+	var buf bytes.Buffer
+	mpw := multipart.NewWriter(&buf)
+	for _, r := range results {
+		header := make(textproto.MIMEHeader)
+		header.Set("TaskId", strconv.FormatInt(r.taskId, 10))
+
+		var body []byte
+		if r.err != nil {
+			header.Set("Content-Type", aiWorkerErrorMimeType)
+			body = []byte(r.err.Error())
+		} else if data, err := json.Marshal(r.result); err != nil {
+			header.Set("Content-Type", aiWorkerErrorMimeType)
+			body = []byte(err.Error())
+		} else {
+			header.Set("Content-Type", "application/json")
+			body = data
+		}
+
+		part, err := mpw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(body); err != nil {
+			return err
+		}
+	}
+	if err := mpw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", "https://"+orchAddr+aiBatchResultsPath, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mpw.Boundary()}))
+	req.Header.Set("Credentials", node.OrchSecret)
+	req.Header.Set("Authorization", protoVerAIWorker)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// any non-2xx is treated the same as an explicit "not implemented" - most likely
+		// an older orchestrator that doesn't have aiResultsBatchHandler mounted at all
+		// (a 404), but falling back either way beats silently losing the batch's results
+		return fmt.Errorf("%w: orchestrator returned status %d", errAIBatchNotImplemented, resp.StatusCode)
+	}
+	return nil
+}
+
+// aiBatchResultSubmitter hands off one item decoded out of a batch result submission to
+// whatever the orchestrator uses to complete a single dispatched job. It's satisfied by the
+// orchestrator's job manager, kept as a narrow local interface here since that manager's
+// definition lives outside this package.
+type aiBatchResultSubmitter interface {
+	SubmitAIResult(taskId int64, contentType string, body []byte)
+}
+
+// aiResultsBatchHandler serves aiBatchResultsPath, decoding a multipart/mixed batch result
+// submitted by postAIBatchResult and handing each part to submitter individually, keyed by
+// its TaskId header, so a batch is completed the same way a single-item result is. Replies
+// with http.StatusBadRequest when the body can't be parsed as a multipart submission.
+func aiResultsBatchHandler(submitter aiBatchResultSubmitter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			taskId, err := strconv.ParseInt(part.Header.Get("TaskId"), 10, 64)
+			if err != nil {
+				continue
+			}
+			body, err := io.ReadAll(part)
+			if err != nil {
+				continue
+			}
+			submitter.SubmitAIResult(taskId, part.Header.Get("Content-Type"), body)
+		}
+	})
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/ai-worker/worker"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+)
+
+// capturedBatchPart is one part of a multipart batch result the stub orchestrator
+// received.
+type capturedBatchPart struct {
+	taskId      string
+	contentType string
+	body        string
+}
+
+// readBatchParts decodes a multipart/mixed batch result body using the Content-Type
+// header it was submitted with.
+func readBatchParts(t *testing.T, contentType string, body []byte) []capturedBatchPart {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	assert.NoError(t, err)
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	var parts []capturedBatchPart
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := io.ReadAll(p)
+		assert.NoError(t, err)
+		parts = append(parts, capturedBatchPart{
+			taskId:      p.Header.Get("TaskId"),
+			contentType: p.Header.Get("Content-Type"),
+			body:        string(data),
+		})
+	}
+	return parts
+}
+
+func createStubAIWorkerBatchCapabilities(pipeline, modelId string) *core.Capabilities {
+	caps := createStubAIWorkerCapabilitiesForPipelineModelId(pipeline, modelId)
+	caps.SetCapabilities([]core.Capability{core.Capability_AIBatchJobs})
+	return caps
+}
+
+// stubAIBatchResultSubmitter records every item aiResultsBatchHandler hands it.
+type stubAIBatchResultSubmitter struct {
+	mu      sync.Mutex
+	results []capturedBatchPart
+}
+
+func (s *stubAIBatchResultSubmitter) SubmitAIResult(taskId int64, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, capturedBatchPart{
+		taskId:      strconv.FormatInt(taskId, 10),
+		contentType: contentType,
+		body:        string(body),
+	})
+}
+
+func TestAIResultsBatchHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	submitter := &stubAIBatchResultSubmitter{}
+	ts := httptest.NewTLSServer(aiResultsBatchHandler(submitter))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+	}
+	results := []aiBatchItemResult{
+		{taskId: 901, result: map[string]string{"ok": "yes"}},
+		{taskId: 902, err: fmt.Errorf("boom")},
+	}
+
+	err := postAIBatchResult(node, parsedURL.Host, httpc, notify, results)
+	assert.NoError(err)
+
+	submitter.mu.Lock()
+	defer submitter.mu.Unlock()
+	assert.Equal(2, len(submitter.results))
+
+	byTaskId := make(map[string]capturedBatchPart)
+	for _, r := range submitter.results {
+		byTaskId[r.taskId] = r
+	}
+	assert.Equal("application/json", byTaskId["901"].contentType)
+	assert.Contains(byTaskId["901"].body, "\"ok\":\"yes\"")
+	assert.Equal(aiWorkerErrorMimeType, byTaskId["902"].contentType)
+	assert.Equal("boom", byTaskId["902"].body)
+}
+
+// TestAIResultsBatchHandler_MountedOnOrchestratorServer checks that aiBatchResultsPath is
+// reachable through the same mux a real node's HTTP server serves, rather than only via a
+// direct handler call from its own unit test.
+func TestAIResultsBatchHandler_MountedOnOrchestratorServer(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newMockAIOrchestratorServer()
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	mpw := multipart.NewWriter(&buf)
+	assert.NoError(mpw.Close())
+
+	req, err := http.NewRequest("POST", srv.URL+aiBatchResultsPath, &buf)
+	assert.NoError(err)
+	req.Header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mpw.Boundary()}))
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.NotEqual(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRunAIJobBatch_Success(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		mu.Lock()
+		headers = r.Header
+		body = out
+		mu.Unlock()
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerBatchCapabilities("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items: []*net.AIJobBatchItem{
+			{TaskId: 101, RequestData: []byte(`{"prompt":"a cat"}`)},
+			{TaskId: 102, RequestData: []byte(`{"prompt":"a dog"}`)},
+		},
+	}
+
+	runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(headers.Get("Content-Type"), "multipart/mixed")
+	parts := readBatchParts(t, headers.Get("Content-Type"), body)
+	assert.Equal(2, len(parts))
+	for _, p := range parts {
+		assert.Equal("application/json", p.contentType)
+		assert.Contains([]string{"101", "102"}, p.taskId)
+	}
+}
+
+func TestRunAIJobBatch_PartialFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		mu.Lock()
+		headers = r.Header
+		body = out
+		mu.Unlock()
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerBatchCapabilities("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items: []*net.AIJobBatchItem{
+			{TaskId: 201, RequestData: []byte(`{"prompt":"a cat"}`)},
+			{TaskId: 202, RequestData: []byte(`invalid json`)},
+			{TaskId: 203, RequestData: []byte(`{"prompt":"a bird"}`)},
+		},
+	}
+
+	runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	parts := readBatchParts(t, headers.Get("Content-Type"), body)
+	assert.Equal(3, len(parts))
+
+	byTaskId := make(map[string]capturedBatchPart)
+	for _, p := range parts {
+		byTaskId[p.taskId] = p
+	}
+	assert.Equal("application/json", byTaskId["201"].contentType)
+	assert.Equal(aiWorkerErrorMimeType, byTaskId["202"].contentType)
+	assert.Contains(byTaskId["202"].body, "AI request not correct")
+	assert.Equal("application/json", byTaskId["203"].contentType)
+}
+
+func TestRunAIJobBatch_UnrecoverableErrorPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{Err: core.NewUnrecoverableError(fmt.Errorf("fatal worker error"))}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerBatchCapabilities("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items: []*net.AIJobBatchItem{
+			{TaskId: 301, RequestData: []byte(`{"prompt":"a cat"}`)},
+		},
+	}
+
+	panicked := false
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = true
+			}
+		}()
+		runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	}()
+	assert.True(panicked)
+}
+
+func TestRunAIJobBatch_FallsBackWhenOrchestratorRejectsBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var singleJobHits int
+	var batchHits int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == aiBatchResultsPath {
+			batchHits++
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		singleJobHits++
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerBatchCapabilities("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items: []*net.AIJobBatchItem{
+			{TaskId: 401, RequestData: []byte(`{"prompt":"a cat"}`)},
+			{TaskId: 402, RequestData: []byte(`{"prompt":"a dog"}`)},
+		},
+	}
+
+	runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(1, batchHits)
+	assert.Equal(2, singleJobHits)
+	assert.True(aiBatchFallbackKnown(parsedURL.Host))
+
+	// a later batch for the same orchestrator should skip the batch attempt entirely
+	batchHits, singleJobHits = 0, 0
+	// the stub handler takes mu too, so release it while the second batch is submitted
+	mu.Unlock()
+	runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(0, batchHits)
+	assert.Equal(2, singleJobHits)
+}
+
+func TestRunAIJobBatch_CapabilityNegotiatedFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var batchHits int
+	var singleJobHits int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == aiBatchResultsPath {
+			batchHits++
+		} else {
+			singleJobHits++
+		}
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	// no batch capability bit set - worker only advertises the single-job pipeline
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items: []*net.AIJobBatchItem{
+			{TaskId: 501, RequestData: []byte(`{"prompt":"a cat"}`)},
+		},
+	}
+
+	runAIJobBatch(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(0, batchHits)
+	assert.Equal(1, singleJobHits)
+}
+
+// capacityLimitedAIWorker tracks how many TextToImage calls are actually running at once, so
+// HasCapacity reflects real in-flight work from earlier items in the same batch rather than a
+// snapshot taken before any of them started.
+type capacityLimitedAIWorker struct {
+	stubAIWorker
+
+	mu          sync.Mutex
+	capacity    int
+	inFlight    int
+	maxInFlight int
+}
+
+func (a *capacityLimitedAIWorker) HasCapacity(pipeline, modelID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inFlight < a.capacity
+}
+
+func (a *capacityLimitedAIWorker) TextToImage(ctx context.Context, req worker.GenTextToImageJSONRequestBody) (*worker.ImageResponse, error) {
+	a.mu.Lock()
+	a.inFlight++
+	if a.inFlight > a.maxInFlight {
+		a.maxInFlight = a.inFlight
+	}
+	a.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	a.mu.Lock()
+	a.inFlight--
+	a.mu.Unlock()
+
+	return a.stubAIWorker.TextToImage(ctx, req)
+}
+
+// TestDispatchAIJobBatchItems_BoundsConcurrency asserts that dispatching a batch larger than
+// the worker's own capacity actually throttles in-flight work to that capacity, rather than
+// checking HasCapacity for every item up front before any of them have started running.
+func TestDispatchAIJobBatchItems_BoundsConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	wkr := &capacityLimitedAIWorker{capacity: 2}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.AIWorker = wkr
+
+	const batchSize = 10
+	items := make([]*net.AIJobBatchItem, batchSize)
+	for i := range items {
+		items[i] = &net.AIJobBatchItem{TaskId: int64(i), RequestData: []byte(`{"prompt":"a cat"}`)}
+	}
+	notify := &net.NotifyAIJobBatch{
+		Pipeline: "text-to-image",
+		ModelID:  "livepeer/model1",
+		Items:    items,
+	}
+
+	results := dispatchAIJobBatchItems(context.Background(), node, notify)
+
+	assert.LessOrEqual(wkr.maxInFlight, wkr.capacity, "batch dispatch let more items run concurrently than the worker's reported capacity")
+	assert.Greater(wkr.maxInFlight, 1, "batch dispatch never actually ran items concurrently")
+
+	var rejected int
+	for _, r := range results {
+		if r.err != nil {
+			rejected++
+		}
+	}
+	assert.Greater(rejected, 0, "batch larger than capacity should have rejected some items instead of silently serializing all of them")
+}
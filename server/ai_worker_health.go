@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// aiHealthLatencyWindow is the number of most recent job latencies kept per
+// pipeline/model for reporting on the health endpoint.
+const aiHealthLatencyWindow = 20
+
+var (
+	aiJobLatenciesMu sync.Mutex
+	aiJobLatencies   = make(map[string][]time.Duration)
+)
+
+// recordAIJobLatency appends d to the rolling latency window kept for pipeline/modelID,
+// trimming it down to aiHealthLatencyWindow entries.
+func recordAIJobLatency(pipeline, modelID string, d time.Duration) {
+	key := pipeline + "/" + modelID
+	aiJobLatenciesMu.Lock()
+	defer aiJobLatenciesMu.Unlock()
+	latencies := append(aiJobLatencies[key], d)
+	if len(latencies) > aiHealthLatencyWindow {
+		latencies = latencies[len(latencies)-aiHealthLatencyWindow:]
+	}
+	aiJobLatencies[key] = latencies
+}
+
+func aiJobLatenciesSnapshot(pipeline, modelID string) []time.Duration {
+	key := pipeline + "/" + modelID
+	aiJobLatenciesMu.Lock()
+	defer aiJobLatenciesMu.Unlock()
+	out := make([]time.Duration, len(aiJobLatencies[key]))
+	copy(out, aiJobLatencies[key])
+	return out
+}
+
+// aiWorkerResourceReporter is an optional interface an AIWorker implementation can
+// satisfy to report GPU memory use and queue depth on the health endpoint. Workers that
+// don't implement it are reported with zero values for those fields.
+type aiWorkerResourceReporter interface {
+	ResourceStatus(pipeline, modelID string) (queueDepth int, gpuMemoryMB int64)
+}
+
+// aiModelHealth is the health snapshot for a single pipeline/model combination this
+// node's AIWorker advertises constraints for.
+type aiModelHealth struct {
+	Warm              bool    `json:"warm"`
+	HasCapacity       bool    `json:"hasCapacity"`
+	QueueDepth        int     `json:"queueDepth"`
+	GPUMemoryMB       int64   `json:"gpuMemoryMb"`
+	RecentLatenciesMs []int64 `json:"recentLatenciesMs"`
+}
+
+// aiPipelineHealth groups the health of every model this node serves for one pipeline.
+type aiPipelineHealth struct {
+	Pipeline string                   `json:"pipeline"`
+	Models   map[string]aiModelHealth `json:"models"`
+}
+
+// aiHealthReport reports, for every pipeline/model this node's AIWorker advertises
+// capacity constraints for, whether the worker can currently accept jobs.
+func aiHealthReport(node *core.LivepeerNode) []aiPipelineHealth {
+	reporter, _ := node.AIWorker.(aiWorkerResourceReporter)
+
+	constraints := node.Capabilities.PerCapabilityConstraints()
+	report := make([]aiPipelineHealth, 0, len(constraints))
+	for capability, capConstraints := range constraints {
+		pipeline, err := core.CapabilityToPipeline(capability)
+		if err != nil {
+			continue
+		}
+
+		models := make(map[string]aiModelHealth, len(capConstraints.Models))
+		for modelID, constraint := range capConstraints.Models {
+			health := aiModelHealth{
+				Warm:        constraint.Warm,
+				HasCapacity: node.AIWorker.HasCapacity(pipeline, modelID),
+			}
+			if reporter != nil {
+				health.QueueDepth, health.GPUMemoryMB = reporter.ResourceStatus(pipeline, modelID)
+			}
+			for _, d := range aiJobLatenciesSnapshot(pipeline, modelID) {
+				health.RecentLatenciesMs = append(health.RecentLatenciesMs, d.Milliseconds())
+			}
+			models[modelID] = health
+		}
+		report = append(report, aiPipelineHealth{Pipeline: pipeline, Models: models})
+	}
+	return report
+}
+
+// aiHealthPath is the path RegisterAIHealthHandler mounts aiHealthHandler on.
+const aiHealthPath = "/aihealth"
+
+// aiHealthHandler serves GET /aihealth, reporting per-pipeline/per-model liveness for
+// this node's AIWorker so the orchestrator (or an external monitor) can route around a
+// worker that is cold, out of capacity, or backed up.
+func aiHealthHandler(node *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if node.AIWorker == nil {
+			http.Error(w, "node is not running an AIWorker", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(aiHealthReport(node))
+	})
+}
+
+// RegisterAIHealthHandler mounts the AI worker health endpoint on mux at aiHealthPath, so
+// whatever HTTP server a worker node runs can expose it alongside its other routes.
+func RegisterAIHealthHandler(mux *http.ServeMux, node *core.LivepeerNode) {
+	mux.Handle(aiHealthPath, aiHealthHandler(node))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResourceAIWorker additionally reports GPU memory and queue depth, exercising the
+// optional aiWorkerResourceReporter interface on the health endpoint.
+type stubResourceAIWorker struct {
+	stubAIWorker
+	QueueDepth  int
+	GPUMemoryMB int64
+}
+
+func (a *stubResourceAIWorker) ResourceStatus(pipeline, modelID string) (int, int64) {
+	return a.QueueDepth, a.GPUMemoryMB
+}
+
+func TestAIHealthHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	wkr := &stubResourceAIWorker{QueueDepth: 2, GPUMemoryMB: 4096}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.AIWorker = wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	// the latency window is shared by the whole package, so drop whatever earlier tests'
+	// runAIJob calls recorded for this pipeline/model first
+	aiJobLatenciesMu.Lock()
+	delete(aiJobLatencies, "text-to-image/livepeer/model1")
+	aiJobLatenciesMu.Unlock()
+
+	recordAIJobLatency("text-to-image", "livepeer/model1", 120*time.Millisecond)
+	recordAIJobLatency("text-to-image", "livepeer/model1", 80*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/aihealth", nil)
+	w := httptest.NewRecorder()
+	aiHealthHandler(node).ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+
+	var report []aiPipelineHealth
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(1, len(report))
+	assert.Equal("text-to-image", report[0].Pipeline)
+
+	model := report[0].Models["livepeer/model1"]
+	assert.True(model.Warm)
+	assert.True(model.HasCapacity)
+	assert.Equal(2, model.QueueDepth)
+	assert.EqualValues(4096, model.GPUMemoryMB)
+	assert.Equal(2, len(model.RecentLatenciesMs))
+}
+
+// TestAIHealthHandler_MountedOnOrchestratorServer checks that /aihealth is reachable
+// through the same mux a real node's HTTP server serves, rather than only via a direct
+// handler call from its own unit test.
+func TestAIHealthHandler_MountedOnOrchestratorServer(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newMockAIOrchestratorServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + aiHealthPath)
+	assert.NoError(err)
+	defer resp.Body.Close()
+	// the mock server's node doesn't run an AIWorker itself, so the handler reports not
+	// found rather than a report - its own error message, unlike the mux's generic 404,
+	// shows that the route is actually mounted.
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(err)
+	assert.Contains(string(respBody), "node is not running an AIWorker")
+}
+
+func TestRegisterAIHealthHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	wkr := &stubAIWorker{}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.AIWorker = wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	mux := http.NewServeMux()
+	RegisterAIHealthHandler(mux, node)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + aiHealthPath)
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var report []aiPipelineHealth
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(1, len(report))
+}
+
+func TestRunAIJob_CapacityExhaustedNacksForRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		headers = r.Header
+		body = out
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{AtCapacity: true}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      601,
+		Pipeline:    "text-to-image",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"a cat"}`),
+	}
+
+	runAIJob(node, parsedURL.Host, httpc, notify)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(aiWorkerRetryMimeType, headers.Get("Content-Type"))
+	assert.Equal("1", headers.Get("Attempt"))
+	assert.NotEmpty(headers.Get("Retry-After"))
+	assert.Contains(string(body), "capacity")
+}
+
+// TestRunAIJob_RetryAttemptsExhausted simulates a job that has already bounced between
+// workers aiRetryMaxAttempts times: rather than NACKing it for yet another retry, the
+// worker gives up and reports it as a terminal error.
+func TestRunAIJob_RetryAttemptsExhausted(t *testing.T) {
+	assert := assert.New(t)
+
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		headers = r.Header
+		body = out
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{AtCapacity: true}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      603,
+		Pipeline:    "text-to-image",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"a cat"}`),
+		Attempt:     aiRetryMaxAttempts,
+	}
+
+	runAIJob(node, parsedURL.Host, httpc, notify)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(aiWorkerErrorMimeType, headers.Get("Content-Type"))
+	assert.Contains(string(body), "capacity")
+}
+
+// TestRunAIJob_SecondAttemptSucceedsOnHealthyWorker checks the worker-local half of a
+// requeue: a job NACKed by a busy worker is still runnable when a second runAIJob call
+// delivers it (with its attempt count incremented, as requeueAIJob would before handing it
+// to another worker) to a healthy one. It does not exercise requeueAIJob itself or any real
+// orchestrator-side worker selection - see ai_worker_requeue_test.go for that.
+func TestRunAIJob_SecondAttemptSucceedsOnHealthyWorker(t *testing.T) {
+	assert := assert.New(t)
+
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		headers = r.Header
+		body = out
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	busyWkr := stubAIWorker{AtCapacity: true}
+	busyNode, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	busyNode.OrchSecret = "verbigsecret"
+	busyNode.AIWorker = &busyWkr
+	busyNode.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      602,
+		Pipeline:    "text-to-image",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"a cat"}`),
+	}
+
+	runAIJob(busyNode, parsedURL.Host, httpc, notify)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(aiWorkerRetryMimeType, headers.Get("Content-Type"))
+	attempt := headers.Get("Attempt")
+	assert.Equal("1", attempt)
+
+	// the orchestrator requeues the job with the incremented attempt count onto a
+	// different, healthy worker
+	requeued := &net.NotifyAIJob{
+		TaskId:      notify.TaskId,
+		Pipeline:    notify.Pipeline,
+		ModelID:     notify.ModelID,
+		RequestData: notify.RequestData,
+		Attempt:     1,
+	}
+
+	healthyWkr := stubAIWorker{}
+	healthyNode, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	healthyNode.OrchSecret = "verbigsecret"
+	healthyNode.AIWorker = &healthyWkr
+	healthyNode.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("text-to-image", "livepeer/model1")
+
+	runAIJob(healthyNode, parsedURL.Host, httpc, requeued)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(1, healthyWkr.Called)
+	assert.NotEqual(aiWorkerErrorMimeType, headers.Get("Content-Type"))
+	assert.NotEqual(aiWorkerRetryMimeType, headers.Get("Content-Type"))
+	assert.NotNil(body)
+}
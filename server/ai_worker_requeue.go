@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// errAIRetryAttemptsExceeded is returned by requeueAIJob when notify has already used up
+// its retry budget, so the caller should give up rather than look for another worker.
+var errAIRetryAttemptsExceeded = errors.New("AI job exceeded max retry attempts")
+
+// errNoEligibleAIWorker is returned by requeueAIJob when pool has no worker left to try
+// that isn't already excluded.
+var errNoEligibleAIWorker = errors.New("no eligible AI worker to requeue job onto")
+
+// aiWorkerPool selects an address for a worker eligible to run pipeline/modelID, excluding
+// any address in exclude. It's satisfied by the orchestrator's real worker pool (e.g.
+// core.RemoteAIWorkerManager), kept as a narrow local interface here since that type's
+// definition lives outside this package.
+type aiWorkerPool interface {
+	SelectAIWorker(pipeline, modelID string, exclude map[string]bool) (addr string, ok bool)
+}
+
+// aiJobDispatchFunc hands notify off to the worker at addr, however the orchestrator
+// actually delivers a job to a connected worker.
+type aiJobDispatchFunc func(addr string, notify *net.NotifyAIJob) error
+
+// requeueAIJob implements the orchestrator side of a worker's retry NACK: it picks a
+// different eligible worker (excluding the one that just NACKed) and redispatches notify
+// with its attempt count incremented, up to aiRetryMaxAttempts. Past that, it gives up
+// rather than bouncing the job between workers forever. It keeps no state between calls:
+// failedWorkerAddr is added to excluded (or to a fresh map when excluded is nil), and only
+// the addresses in that map are excluded from selection.
+func requeueAIJob(pool aiWorkerPool, dispatch aiJobDispatchFunc, notify *net.NotifyAIJob, failedWorkerAddr string, excluded map[string]bool) error {
+	if notify.Attempt >= aiRetryMaxAttempts {
+		return errAIRetryAttemptsExceeded
+	}
+
+	if excluded == nil {
+		excluded = make(map[string]bool, 1)
+	}
+	excluded[failedWorkerAddr] = true
+
+	addr, ok := pool.SelectAIWorker(notify.Pipeline, notify.ModelID, excluded)
+	if !ok {
+		return errNoEligibleAIWorker
+	}
+
+	requeued := *notify
+	requeued.Attempt = notify.Attempt + 1
+	return dispatch(addr, &requeued)
+}
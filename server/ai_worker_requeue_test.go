@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubAIWorkerPool is a fixed address->eligible map, letting a test control exactly which
+// worker addresses requeueAIJob is allowed to pick.
+type stubAIWorkerPool struct {
+	eligible []string
+}
+
+func (p *stubAIWorkerPool) SelectAIWorker(pipeline, modelID string, exclude map[string]bool) (string, bool) {
+	for _, addr := range p.eligible {
+		if !exclude[addr] {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+func TestRequeueAIJob_SelectsDifferentWorker(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := &stubAIWorkerPool{eligible: []string{"worker-a", "worker-b"}}
+	var dispatchedAddr string
+	var dispatchedNotify *net.NotifyAIJob
+	dispatch := func(addr string, notify *net.NotifyAIJob) error {
+		dispatchedAddr = addr
+		dispatchedNotify = notify
+		return nil
+	}
+
+	notify := &net.NotifyAIJob{TaskId: 1, Pipeline: "text-to-image", ModelID: "livepeer/model1", Attempt: 0}
+
+	err := requeueAIJob(pool, dispatch, notify, "worker-a", nil)
+	assert.NoError(err)
+	assert.Equal("worker-b", dispatchedAddr)
+	assert.EqualValues(1, dispatchedNotify.Attempt)
+	// the original notify is left untouched - only the redispatched copy is incremented
+	assert.EqualValues(0, notify.Attempt)
+}
+
+func TestRequeueAIJob_NoEligibleWorker(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := &stubAIWorkerPool{eligible: []string{"worker-a"}}
+	dispatch := func(addr string, notify *net.NotifyAIJob) error {
+		t.Fatal("dispatch should not be called when no eligible worker remains")
+		return nil
+	}
+
+	notify := &net.NotifyAIJob{TaskId: 2, Pipeline: "text-to-image", ModelID: "livepeer/model1"}
+
+	err := requeueAIJob(pool, dispatch, notify, "worker-a", nil)
+	assert.True(errors.Is(err, errNoEligibleAIWorker))
+}
+
+func TestRequeueAIJob_MaxAttemptsExceeded(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := &stubAIWorkerPool{eligible: []string{"worker-a", "worker-b"}}
+	dispatch := func(addr string, notify *net.NotifyAIJob) error {
+		t.Fatal("dispatch should not be called once retry attempts are exhausted")
+		return nil
+	}
+
+	notify := &net.NotifyAIJob{TaskId: 3, Pipeline: "text-to-image", ModelID: "livepeer/model1", Attempt: aiRetryMaxAttempts}
+
+	err := requeueAIJob(pool, dispatch, notify, "worker-a", nil)
+	assert.True(errors.Is(err, errAIRetryAttemptsExceeded))
+}
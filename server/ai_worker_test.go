@@ -12,6 +12,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -66,7 +68,8 @@ func TestRemoteAIWorker_Error(t *testing.T) {
 	}))
 	defer ts.Close()
 	parsedURL, _ := url.Parse(ts.URL)
-	//send empty request data
+	//send empty request data - malformed requests fail identically on any worker, so they
+	//are reported as a terminal error rather than NACKed for retry
 	runAIJob(node, parsedURL.Host, httpc, notify)
 	time.Sleep(3 * time.Millisecond)
 
@@ -78,7 +81,8 @@ func TestRemoteAIWorker_Error(t *testing.T) {
 	assert.Equal(protoVerAIWorker, headers.Get("Authorization"))
 	assert.NotNil(string(body))
 
-	//error in worker, good request
+	//error in worker, good request - a transient worker-side failure is NACKed so the
+	//orchestrator can requeue it onto another worker
 	errText := "Some error"
 	wkr.Err = fmt.Errorf(errText)
 
@@ -90,7 +94,8 @@ func TestRemoteAIWorker_Error(t *testing.T) {
 	assert.Equal(1, wkr.Called)
 	assert.NotNil(body)
 	assert.Equal("742", headers.Get("TaskId"))
-	assert.Equal(aiWorkerErrorMimeType, headers.Get("Content-Type"))
+	assert.Equal(aiWorkerRetryMimeType, headers.Get("Content-Type"))
+	assert.Equal("1", headers.Get("Attempt"))
 	assert.Equal(node.OrchSecret, headers.Get("Credentials"))
 	assert.Equal(protoVerAIWorker, headers.Get("Authorization"))
 	assert.Equal(errText, string(body))
@@ -359,6 +364,213 @@ func TestRunAIJob(t *testing.T) {
 	}
 }
 
+// capturedFrame is one HTTP POST received by the orchestrator stub server during an SSE
+// streaming test.
+type capturedFrame struct {
+	headers http.Header
+	body    []byte
+}
+
+func TestRunAIJob_LLMStream(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var frames []capturedFrame
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		mu.Lock()
+		frames = append(frames, capturedFrame{headers: r.Header.Clone(), body: out})
+		mu.Unlock()
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{StreamTokens: []string{"once ", "upon ", "a ", "time"}}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("llm", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      9,
+		Pipeline:    "llm",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"tell me a story","max_tokens":10,"stream":true}`),
+	}
+
+	runAIJob(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(len(wkr.StreamTokens)+1, len(frames), "one frame per token plus a terminating [DONE] frame")
+
+	var seen []string
+	for i, f := range frames {
+		assert.Equal("9", f.headers.Get("TaskId"))
+		assert.Equal(aiWorkerStreamMimeType, f.headers.Get("Content-Type"))
+		assert.Equal(strconv.Itoa(i), f.headers.Get("Seq"))
+		seen = append(seen, string(f.body))
+	}
+	for i, tok := range wkr.StreamTokens {
+		assert.Contains(seen[i], tok)
+	}
+	assert.Equal("data: [DONE]\n\n", seen[len(seen)-1])
+}
+
+// TestRunAIJob_LLMStream_TransientErrorBeforeStreamStarts asserts that a transient AIWorker
+// failure before any frame has been sent is NACKed for retry, the same as a non-streaming
+// job's transient failure, rather than always being surfaced as a terminal error.
+func TestRunAIJob_LLMStream_TransientErrorBeforeStreamStarts(t *testing.T) {
+	assert := assert.New(t)
+
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		headers = r.Header
+		body = out
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	errText := "backend hiccup"
+	wkr := stubAIWorker{Err: fmt.Errorf(errText)}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("llm", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      12,
+		Pipeline:    "llm",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"tell me a story","max_tokens":10,"stream":true}`),
+	}
+
+	runAIJob(node, parsedURL.Host, httpc, notify)
+	time.Sleep(3 * time.Millisecond)
+
+	assert.Equal(aiWorkerRetryMimeType, headers.Get("Content-Type"))
+	assert.Equal("1", headers.Get("Attempt"))
+	assert.NotEmpty(headers.Get("Retry-After"))
+	assert.Equal(errText, string(body))
+}
+
+// TestRunAIJob_LLMStream_CancelMidStream asserts that once the orchestrator stops
+// accepting frames for a job (simulated here by shutting the server down partway through
+// the stream), runAIJobStream stops posting frames and returns instead of delivering every
+// token. Cancellation of the context handed to AIWorker.LLM is not observed directly.
+func TestRunAIJob_LLMStream_CancelMidStream(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var frames []capturedFrame
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		mu.Lock()
+		frames = append(frames, capturedFrame{headers: r.Header.Clone(), body: out})
+		n := len(frames)
+		mu.Unlock()
+		w.Write(nil)
+		if n == 2 {
+			go ts.CloseClientConnections()
+			go ts.Close()
+		}
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{StreamTokens: []string{"a", "b", "c", "d", "e"}}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("llm", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      10,
+		Pipeline:    "llm",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"tell me a story","max_tokens":10,"stream":true}`),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runAIJob(node, parsedURL.Host, httpc, notify)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runAIJob did not return after mid-stream cancellation")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Less(len(frames), len(wkr.StreamTokens)+1, "stream should stop before delivering every token")
+}
+
+func TestRunAIJob_LLMStream_ErrorMidStream(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var frames []capturedFrame
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		mu.Lock()
+		frames = append(frames, capturedFrame{headers: r.Header.Clone(), body: out})
+		mu.Unlock()
+		w.Write(nil)
+	}))
+	defer ts.Close()
+	parsedURL, _ := url.Parse(ts.URL)
+
+	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	wkr := stubAIWorker{
+		StreamTokens: []string{"once ", "upon ", "a ", "time"},
+		StreamErrAt:  2,
+		StreamErr:    fmt.Errorf("worker exploded"),
+	}
+	node, _ := core.NewLivepeerNode(nil, "/tmp/thisdirisnotactuallyusedinthistest", nil)
+	node.OrchSecret = "verbigsecret"
+	node.AIWorker = &wkr
+	node.Capabilities = createStubAIWorkerCapabilitiesForPipelineModelId("llm", "livepeer/model1")
+
+	notify := &net.NotifyAIJob{
+		TaskId:      11,
+		Pipeline:    "llm",
+		ModelID:     "livepeer/model1",
+		RequestData: []byte(`{"prompt":"tell me a story","max_tokens":10,"stream":true}`),
+	}
+
+	runAIJob(node, parsedURL.Host, httpc, notify)
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// one token frame before the failure, then a single terminating SSE error frame
+	assert.Equal(2, len(frames))
+	assert.Equal(aiWorkerStreamMimeType, frames[0].headers.Get("Content-Type"))
+	assert.Contains(string(frames[0].body), "once ")
+
+	assert.Equal(aiWorkerStreamMimeType, frames[1].headers.Get("Content-Type"))
+	assert.Equal("event: error\ndata: worker exploded\n\n", string(frames[1].body))
+}
+
 func aiResultsTest(l lphttp, w *httptest.ResponseRecorder, r *http.Request) (int, string) {
 	handler := l.AIResults()
 	handler.ServeHTTP(w, r)
@@ -375,10 +587,19 @@ func newMockAIOrchestratorServer() *httptest.Server {
 	n.AIWorkerManager = core.NewRemoteAIWorkerManager()
 	s, _ := NewLivepeerServer("127.0.0.1:1938", n, true, "")
 	mux := s.cliWebServerHandlers("addr")
+	RegisterAIHealthHandler(mux, n)
+	mux.Handle(aiBatchResultsPath, aiResultsBatchHandler(noopAIBatchResultSubmitter{}))
 	srv := httptest.NewServer(mux)
 	return srv
 }
 
+// noopAIBatchResultSubmitter is a placeholder aiBatchResultSubmitter that discards every
+// item, wired in just so aiResultsBatchHandler is actually mounted on a server under test;
+// a real orchestrator hands decoded items to its AIWorkerManager instead.
+type noopAIBatchResultSubmitter struct{}
+
+func (noopAIBatchResultSubmitter) SubmitAIResult(taskId int64, contentType string, body []byte) {}
+
 func connectWorker(n *core.LivepeerNode) {
 	strm := &StubAIWorkerServer{}
 	caps := createStubAIWorkerCapabilities()
@@ -443,6 +664,18 @@ func (s *StubAIWorkerServer) Send(n *net.NotifyAIJob) error {
 type stubAIWorker struct {
 	Called int
 	Err    error
+
+	// StreamTokens, when set, are emitted in order by LLM for a streaming request.
+	// Defaults to a two-token completion when left empty.
+	StreamTokens []string
+	// StreamErrAt, when > 0, makes LLM emit StreamErr instead of the StreamTokens[n-1]
+	// token, simulating a failure partway through a stream.
+	StreamErrAt int
+	StreamErr   error
+
+	// AtCapacity, when true, makes HasCapacity report that the worker cannot take on
+	// any more work for the requested pipeline/model.
+	AtCapacity bool
 }
 
 func (a *stubAIWorker) TextToImage(ctx context.Context, req worker.GenTextToImageJSONRequestBody) (*worker.ImageResponse, error) {
@@ -552,9 +785,33 @@ func (a *stubAIWorker) LLM(ctx context.Context, req worker.GenLLMFormdataRequest
 	a.Called++
 	if a.Err != nil {
 		return nil, a.Err
-	} else {
-		return &worker.LLMResponse{Response: "output tokens", TokensUsed: 10}, nil
 	}
+	if req.Stream != nil && *req.Stream {
+		tokens := a.StreamTokens
+		if len(tokens) == 0 {
+			tokens = []string{"output ", "tokens"}
+		}
+		ch := make(chan aiLLMStreamEvent, len(tokens))
+		go func() {
+			defer close(ch)
+			for i, tok := range tokens {
+				if a.StreamErrAt == i+1 {
+					select {
+					case <-ctx.Done():
+					case ch <- aiLLMStreamEvent{Err: a.StreamErr}:
+					}
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- aiLLMStreamEvent{Chunk: &worker.LLMResponse{Response: tok}}:
+				}
+			}
+		}()
+		return ch, nil
+	}
+	return &worker.LLMResponse{Response: "output tokens", TokensUsed: 10}, nil
 }
 
 func (a *stubAIWorker) Warm(ctx context.Context, arg1, arg2 string, endpoint worker.RunnerEndpoint, flags worker.OptimizationFlags) error {
@@ -568,6 +825,5 @@ func (a *stubAIWorker) Stop(ctx context.Context) error {
 }
 
 func (a *stubAIWorker) HasCapacity(pipeline, modelID string) bool {
-	a.Called++
-	return true
-}
\ No newline at end of file
+	return !a.AtCapacity
+}